@@ -0,0 +1,58 @@
+package protocol
+
+import "hash/crc32"
+
+// Checksum is the hash.Hash32-shaped interface that encoder.setCRC accepts.
+// It is intentionally smaller than hash.Hash32 (no io.Writer, no Size/
+// BlockSize) so that callers can plug in checksums, like XXH64, that don't
+// naturally fit the full hash.Hash interface.
+type Checksum interface {
+	Update(b []byte)
+	Sum32() uint32
+	Reset()
+}
+
+// crc32Checksum is a checksum backed by hash/crc32. On amd64 and arm64,
+// hash/crc32 dispatches crc32.Update for the Castagnoli table to the CPU's
+// hardware CRC32 instructions at runtime, so this is the implementation
+// that should be used for anything that crosses the wire (record batches,
+// produce/fetch request CRCs).
+type crc32Checksum struct {
+	table *crc32.Table
+	sum   uint32
+}
+
+func newCRC32Checksum(table *crc32.Table) *crc32Checksum {
+	return &crc32Checksum{table: table}
+}
+
+func (c *crc32Checksum) Update(b []byte) { c.sum = crc32.Update(c.sum, c.table, b) }
+func (c *crc32Checksum) Sum32() uint32   { return c.sum }
+func (c *crc32Checksum) Reset()          { c.sum = 0 }
+
+// NewCRC32C returns a checksum computing CRC32-C (Castagnoli), the
+// polynomial Kafka uses for record batch and message CRCs.
+func NewCRC32C() Checksum { return newCRC32Checksum(crc32.MakeTable(crc32.Castagnoli)) }
+
+// NewCRC32IEEE returns a checksum computing the IEEE CRC32 polynomial, used
+// by the legacy (pre-KIP-98) message format.
+func NewCRC32IEEE() Checksum { return newCRC32Checksum(crc32.IEEETable) }
+
+// xxh64Checksum adapts the streaming XXH64 digest to the checksum
+// interface for callers that don't need the result to match what a broker
+// would compute (e.g. verifying locally cached record batches read back
+// off disk), and want the 5-10x throughput XXH64 gets over CRC32 on bulk
+// data without hardware acceleration.
+type xxh64Checksum struct {
+	digest *xxh64
+}
+
+// NewXXH64 returns a checksum computing XXH64, truncated to 32 bits
+// through Sum32 to satisfy the Checksum interface. It is not wire
+// compatible with any Kafka CRC field and must only be used for internal
+// integrity checks.
+func NewXXH64() Checksum { return &xxh64Checksum{digest: newXXH64()} }
+
+func (c *xxh64Checksum) Update(b []byte) { c.digest.Write(b) }
+func (c *xxh64Checksum) Sum32() uint32   { return uint32(c.digest.Sum64()) }
+func (c *xxh64Checksum) Reset()          { c.digest.Reset() }
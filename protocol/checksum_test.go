@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"hash/crc32"
+	"testing"
+)
+
+func TestCRC32CChecksum(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+
+	table := crc32.MakeTable(crc32.Castagnoli)
+	want := crc32.Checksum(data, table)
+
+	c := NewCRC32C()
+	c.Update(data)
+	if got := c.Sum32(); got != want {
+		t.Errorf("CRC32C checksum = %#x, want %#x", got, want)
+	}
+
+	c.Reset()
+	if got := c.Sum32(); got != 0 {
+		t.Errorf("CRC32C checksum after Reset = %#x, want 0", got)
+	}
+}
+
+func TestCRC32IEEEChecksum(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	want := crc32.ChecksumIEEE(data)
+
+	c := NewCRC32IEEE()
+	c.Update(data)
+	if got := c.Sum32(); got != want {
+		t.Errorf("CRC32 IEEE checksum = %#x, want %#x", got, want)
+	}
+}
+
+// TestCRC32ChecksumIncremental checks that splitting Update across
+// multiple calls (as encoder.update does, one field at a time) gives the
+// same result as a single Update over the whole input.
+func TestCRC32ChecksumIncremental(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+
+	whole := NewCRC32C()
+	whole.Update(data)
+
+	parts := NewCRC32C()
+	for i := 0; i < len(data); i++ {
+		parts.Update(data[i : i+1])
+	}
+
+	if whole.Sum32() != parts.Sum32() {
+		t.Errorf("incremental CRC32C = %#x, want %#x", parts.Sum32(), whole.Sum32())
+	}
+}
+
+func TestXXH64ChecksumTruncatesTo32Bits(t *testing.T) {
+	data := []byte("abc")
+
+	c := NewXXH64()
+	c.Update(data)
+
+	d := newXXH64()
+	d.Write(data)
+	want := uint32(d.Sum64())
+
+	if got := c.Sum32(); got != want {
+		t.Errorf("XXH64 checksum = %#x, want %#x", got, want)
+	}
+
+	c.Reset()
+	const emptySum32 = 0x51d8e999 // low 32 bits of XXH64("") = 0xef46db3751d8e999
+	if got := c.Sum32(); got != emptySum32 {
+		t.Errorf("XXH64 checksum after Reset = %#x, want %#x", got, emptySum32)
+	}
+}
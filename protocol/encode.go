@@ -1,18 +1,148 @@
 package protocol
 
 import (
+	"bufio"
 	"encoding/binary"
-	"hash/crc32"
 	"io"
+	"net"
 	"reflect"
+	"sort"
 )
 
+// encWriter is the low-level sink that an encoder writes primitives to. It
+// is implemented once over a growable byte slice (bytesEncWriter) for the
+// allocation-free MarshalAppend path, and once over an io.Writer
+// (ioEncWriter) for the streaming path used when writing requests and
+// responses straight to the connection. This mirrors the split that mature
+// reflection-based codecs (e.g. ugorji's codec package) use to keep the hot
+// field-by-field encode loop free of interface dispatch through
+// io.Writer.Write for every two- or four-byte integer.
+type encWriter interface {
+	io.Writer
+
+	writen1(b byte)
+	writen2(a, b byte)
+	writen4(a, b, c, d byte)
+	writeb(b []byte)
+
+	// writestr writes s without the caller having to convert it to a
+	// []byte first, so that encoder.WriteString can hand strings straight
+	// to the sink instead of chunking them through a scratch buffer.
+	writestr(s string)
+
+	// lastError returns the first error encountered while writing, or nil.
+	// bytesEncWriter never fails, so it always returns nil.
+	lastError() error
+}
+
+// bytesEncWriter is an encWriter backed by a plain byte slice. It is used
+// by MarshalAppend and appends to the caller's buf, which reallocates only
+// when buf's capacity runs out.
+type bytesEncWriter struct {
+	buf []byte
+}
+
+func (w *bytesEncWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	return len(b), nil
+}
+
+func (w *bytesEncWriter) writen1(b byte)          { w.buf = append(w.buf, b) }
+func (w *bytesEncWriter) writen2(a, b byte)       { w.buf = append(w.buf, a, b) }
+func (w *bytesEncWriter) writen4(a, b, c, d byte) { w.buf = append(w.buf, a, b, c, d) }
+func (w *bytesEncWriter) writeb(b []byte)         { w.buf = append(w.buf, b...) }
+func (w *bytesEncWriter) writestr(s string)       { w.buf = append(w.buf, s...) }
+func (w *bytesEncWriter) lastError() error        { return nil }
+
+// ioEncWriterBufferSize is the size of the bufio-style buffer ioEncWriter
+// accumulates field writes into before flushing to the wrapped io.Writer.
+const ioEncWriterBufferSize = 4096
+
+// ioEncWriter is an encWriter backed by an io.Writer. Field writes
+// (writen1/writen2/writen4/writeb/writestr) accumulate in an internal
+// buffer instead of flushing to raw on every call, so that a message made
+// up of many small int8/int16/int32 fields costs one syscall per flush
+// instead of one per field. flush (and therefore rawWrite, which flushes
+// before handing out raw) must be called once encoding a message is done.
+type ioEncWriter struct {
+	raw io.Writer
+	buf *bufio.Writer
+	err error
+}
+
+func newIOEncWriter(w io.Writer) *ioEncWriter {
+	return &ioEncWriter{raw: w, buf: bufio.NewWriterSize(w, ioEncWriterBufferSize)}
+}
+
+func (w *ioEncWriter) Write(b []byte) (int, error) {
+	n, err := w.buf.Write(b)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *ioEncWriter) writen1(b byte) {
+	if w.err != nil {
+		return
+	}
+	if err := w.buf.WriteByte(b); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *ioEncWriter) writen2(a, b byte) {
+	w.writen1(a)
+	w.writen1(b)
+}
+
+func (w *ioEncWriter) writen4(a, b, c, d byte) {
+	w.writen1(a)
+	w.writen1(b)
+	w.writen1(c)
+	w.writen1(d)
+}
+
+func (w *ioEncWriter) writeb(b []byte) {
+	if w.err != nil {
+		return
+	}
+	if _, err := w.buf.Write(b); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *ioEncWriter) writestr(s string) {
+	if w.err != nil {
+		return
+	}
+	if _, err := w.buf.WriteString(s); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *ioEncWriter) lastError() error { return w.err }
+
+// flush drains the internal buffer to raw. It must be called after the
+// last field of a message has been written (Marshal does this) and before
+// rawWrite hands raw out for a sendfile/ReadFrom fast path, so that bytes
+// already queued in buf aren't reordered after bytes written straight to
+// raw.
+func (w *ioEncWriter) flush() error {
+	if w.err == nil {
+		if err := w.buf.Flush(); err != nil {
+			w.err = err
+		}
+	}
+	return w.err
+}
+
 type encoder struct {
-	writer io.Writer
-	err    error
-	table  *crc32.Table
-	crc32  uint32
-	buffer [32]byte
+	writer     encWriter
+	err        error
+	crc        Checksum
+	updateFunc func([]byte)
+	crcBuf     [32]byte
 }
 
 type encoderChecksum struct {
@@ -29,7 +159,7 @@ func (e *encoderChecksum) Read(b []byte) (int, error) {
 }
 
 func (e *encoder) ReadFrom(r io.Reader) (int64, error) {
-	if e.table != nil {
+	if e.crc != nil {
 		r = &encoderChecksum{
 			reader:  r,
 			encoder: e,
@@ -53,40 +183,59 @@ func (e *encoder) Write(b []byte) (int, error) {
 }
 
 func (e *encoder) WriteString(s string) (int, error) {
-	// This implementation is an optimization to avoid the heap allocation that
-	// would occur when converting the string to a []byte to call crc32.Update.
-	//
-	// Strings are rarely long in the kafka protocol, so the use of a 32 byte
-	// buffer is a good comprise between keeping the encoder value small and
-	// limiting the number of calls to Write.
-	//
-	// We introduced this optimization because memory profiles on the benchmarks
-	// showed that most heap allocations were caused by this code path.
-	n := 0
+	if e.err != nil {
+		return 0, e.err
+	}
 
-	for len(s) != 0 {
-		c := copy(e.buffer[:], s)
-		w, err := e.Write(e.buffer[:c])
-		n += w
-		if err != nil {
-			return n, err
-		}
-		s = s[c:]
+	// writestr hands s straight to the sink without the caller converting
+	// it to a []byte first; bytesEncWriter appends it directly, and
+	// ioEncWriter chunks it through its own scratch buffer. The checksum
+	// still needs a []byte, so that part is chunked through e.crcBuf here,
+	// same as the baseline did for the whole write before writestr existed.
+	e.writer.writestr(s)
+	if !e.syncErr() {
+		return 0, e.err
+	}
+
+	for t := s; len(t) != 0; {
+		n := copy(e.crcBuf[:], t)
+		e.update(e.crcBuf[:n])
+		t = t[n:]
 	}
 
-	return n, nil
+	return len(s), nil
 }
 
-func (e *encoder) setCRC(table *crc32.Table) {
-	e.table, e.crc32 = table, 0
+// newEncoder returns an encoder writing to w with no checksum configured.
+// Every encoder must be built through this constructor (or copy its
+// behavior) so that updateFunc is always resolved up front: update itself
+// stays a single indirect call with no nil check on the hot path.
+func newEncoder(w encWriter) *encoder {
+	e := &encoder{writer: w}
+	e.setCRC(nil)
+	return e
 }
 
-func (e *encoder) update(b []byte) {
-	if e.table != nil {
-		e.crc32 = crc32.Update(e.crc32, e.table, b)
+// setCRC configures the checksum that update calls as the encoder writes,
+// or disables checksumming entirely when crc is nil. The nil/non-nil
+// branch is resolved here, once, rather than on every call to update: the
+// write hot loop just calls e.updateFunc, which is a no-op function
+// pointer when no checksum is configured.
+func (e *encoder) setCRC(crc Checksum) {
+	e.crc = crc
+	if crc != nil {
+		e.updateFunc = crc.Update
+	} else {
+		e.updateFunc = noopUpdate
 	}
 }
 
+func noopUpdate([]byte) {}
+
+func (e *encoder) update(b []byte) {
+	e.updateFunc(b)
+}
+
 func (e *encoder) encodeBool(v value) {
 	b := int8(0)
 	if v.bool() {
@@ -119,6 +268,14 @@ func (e *encoder) encodeNullString(v value) {
 	e.writeNullString(v.string())
 }
 
+func (e *encoder) encodeCompactString(v value) {
+	e.writeCompactString(v.string())
+}
+
+func (e *encoder) encodeCompactNullString(v value) {
+	e.writeCompactNullString(v.string())
+}
+
 func (e *encoder) encodeBytes(v value) {
 	e.writeBytes(v.bytes())
 }
@@ -127,6 +284,14 @@ func (e *encoder) encodeNullBytes(v value) {
 	e.writeNullBytes(v.bytes())
 }
 
+func (e *encoder) encodeCompactBytes(v value) {
+	e.writeCompactBytes(v.bytes())
+}
+
+func (e *encoder) encodeCompactNullBytes(v value) {
+	e.writeCompactNullBytes(v.bytes())
+}
+
 func (e *encoder) encodeArray(v value, elemType reflect.Type, encodeElem encodeFunc) {
 	a := v.array(elemType)
 	n := a.length()
@@ -152,24 +317,89 @@ func (e *encoder) encodeNullArray(v value, elemType reflect.Type, encodeElem enc
 	}
 }
 
+func (e *encoder) encodeCompactArray(v value, elemType reflect.Type, encodeElem encodeFunc) {
+	a := v.array(elemType)
+	n := a.length()
+	e.writeCompactArrayLen(n)
+
+	for i := 0; i < n; i++ {
+		encodeElem(e, a.index(i))
+	}
+}
+
+func (e *encoder) encodeCompactNullArray(v value, elemType reflect.Type, encodeElem encodeFunc) {
+	a := v.array(elemType)
+	if a.isNil() {
+		e.writeUnsignedVarInt(0)
+		return
+	}
+
+	n := a.length()
+	e.writeCompactArrayLen(n)
+
+	for i := 0; i < n; i++ {
+		encodeElem(e, a.index(i))
+	}
+}
+
 func (e *encoder) writeInt8(i int8) {
-	writeInt8(e.buffer[:1], i)
-	e.Write(e.buffer[:1])
+	if e.err != nil {
+		return
+	}
+	b := byte(i)
+	e.writer.writen1(b)
+	if e.syncErr() {
+		e.crcBuf[0] = b
+		e.update(e.crcBuf[:1])
+	}
 }
 
 func (e *encoder) writeInt16(i int16) {
-	writeInt16(e.buffer[:2], i)
-	e.Write(e.buffer[:2])
+	if e.err != nil {
+		return
+	}
+	u := uint16(i)
+	a, b := byte(u>>8), byte(u)
+	e.writer.writen2(a, b)
+	if e.syncErr() {
+		e.crcBuf[0], e.crcBuf[1] = a, b
+		e.update(e.crcBuf[:2])
+	}
 }
 
 func (e *encoder) writeInt32(i int32) {
-	writeInt32(e.buffer[:4], i)
-	e.Write(e.buffer[:4])
+	if e.err != nil {
+		return
+	}
+	u := uint32(i)
+	a, b, c, d := byte(u>>24), byte(u>>16), byte(u>>8), byte(u)
+	e.writer.writen4(a, b, c, d)
+	if e.syncErr() {
+		e.crcBuf[0], e.crcBuf[1], e.crcBuf[2], e.crcBuf[3] = a, b, c, d
+		e.update(e.crcBuf[:4])
+	}
 }
 
 func (e *encoder) writeInt64(i int64) {
-	writeInt64(e.buffer[:8], i)
-	e.Write(e.buffer[:8])
+	if e.err != nil {
+		return
+	}
+	binary.BigEndian.PutUint64(e.crcBuf[:8], uint64(i))
+	e.writer.writeb(e.crcBuf[:8])
+	if e.syncErr() {
+		e.update(e.crcBuf[:8])
+	}
+}
+
+// syncErr pulls any error recorded by the underlying encWriter onto e.err
+// and reports whether the encoder is still error-free. It lets the
+// writen1/writen2/writen4/writeb fast paths below skip the per-call error
+// return that io.Writer.Write would otherwise force onto every primitive.
+func (e *encoder) syncErr() bool {
+	if e.err == nil {
+		e.err = e.writer.lastError()
+	}
+	return e.err == nil
 }
 
 func (e *encoder) writeString(s string) {
@@ -187,15 +417,15 @@ func (e *encoder) writeNullString(s string) {
 }
 
 func (e *encoder) writeCompactString(s string) {
-	e.writeVarInt(int64(len(s)))
+	e.writeCompactStringLen(len(s))
 	e.WriteString(s)
 }
 
 func (e *encoder) writeCompactNullString(s string) {
 	if s == "" {
-		e.writeVarInt(-1)
+		e.writeUnsignedVarInt(0)
 	} else {
-		e.writeVarInt(int64(len(s)))
+		e.writeCompactStringLen(len(s))
 		e.WriteString(s)
 	}
 }
@@ -215,15 +445,15 @@ func (e *encoder) writeNullBytes(b []byte) {
 }
 
 func (e *encoder) writeCompactBytes(b []byte) {
-	e.writeVarInt(int64(len(b)))
+	e.writeCompactBytesLen(len(b))
 	e.Write(b)
 }
 
 func (e *encoder) writeCompactNullBytes(b []byte) {
 	if b == nil {
-		e.writeVarInt(-1)
+		e.writeUnsignedVarInt(0)
 	} else {
-		e.writeVarInt(int64(len(b)))
+		e.writeCompactBytesLen(len(b))
 		e.Write(b)
 	}
 }
@@ -231,7 +461,7 @@ func (e *encoder) writeCompactNullBytes(b []byte) {
 func (e *encoder) writeBytesFrom(b Bytes) error {
 	size := b.Size()
 	e.writeInt32(int32(size))
-	n, err := io.Copy(e, b)
+	n, err := e.copyBytesFrom(b)
 	if err == nil && n != size {
 		err = errorf("size of bytes does not match the number of bytes that were written (size=%d, written=%d)", size, n)
 	}
@@ -245,7 +475,7 @@ func (e *encoder) writeNullBytesFrom(b Bytes) error {
 	} else {
 		size := b.Size()
 		e.writeInt32(int32(size))
-		n, err := io.Copy(e, b)
+		n, err := e.copyBytesFrom(b)
 		if err == nil && n != size {
 			err = errorf("size of nullable bytes does not match the number of bytes that were written (size=%d, written=%d)", size, n)
 		}
@@ -255,12 +485,12 @@ func (e *encoder) writeNullBytesFrom(b Bytes) error {
 
 func (e *encoder) writeCompactNullBytesFrom(b Bytes) error {
 	if b == nil {
-		e.writeVarInt(-1)
+		e.writeUnsignedVarInt(0)
 		return nil
 	} else {
 		size := b.Size()
-		e.writeVarInt(size)
-		n, err := io.Copy(e, b)
+		e.writeCompactBytesLen(int(size))
+		n, err := e.copyBytesFrom(b)
 		if err == nil && n != size {
 			err = errorf("size of compact nullable bytes does not match the number of bytes that were written (size=%d, written=%d)", size, n)
 		}
@@ -268,23 +498,157 @@ func (e *encoder) writeCompactNullBytesFrom(b Bytes) error {
 	}
 }
 
+// SendfileBytes is implemented by Bytes values that are backed by an
+// *os.File (or similar kernel-visible source) and can copy themselves onto
+// a TCP connection without a userspace copy, e.g. via sendfile(2)/splice
+// on Linux. writeBytesFrom and friends use it when the destination and the
+// CRC configuration allow it; other Bytes implementations are unaffected.
+type SendfileBytes interface {
+	SendfileTo(conn *net.TCPConn) (int64, error)
+}
+
+// rawWriter is implemented by encWriter backends that wrap a concrete
+// io.Writer destination, as opposed to an in-memory buffer, so that
+// copyBytesFrom can reach through the encWriter abstraction to discover a
+// sink worth handing directly to the kernel.
+type rawWriter interface {
+	rawWrite() io.Writer
+}
+
+func (w *ioEncWriter) rawWrite() io.Writer {
+	w.flush()
+	return w.raw
+}
+
+// copyBytesFrom copies b onto the encoder's destination. When no checksum
+// is configured, the record set backing b was already checksummed at build
+// time, so there's nothing to gain from routing the bytes through
+// encoder.Write: this takes the kernel-level sendfile/splice path when the
+// destination is a *net.TCPConn and b knows how to feed itself to one, or
+// hands b straight to the destination's ReadFrom when the destination
+// merely exposes io.ReaderFrom. Any other combination falls back to
+// today's behavior of copying through the encoder (and its checksum).
+func (e *encoder) copyBytesFrom(b Bytes) (int64, error) {
+	if e.crc == nil {
+		if rw, ok := e.writer.(rawWriter); ok {
+			dst := rw.rawWrite()
+			if conn, ok := dst.(*net.TCPConn); ok {
+				if sf, ok := b.(SendfileBytes); ok {
+					n, err := sf.SendfileTo(conn)
+					if err != nil && e.err == nil {
+						e.err = err
+					}
+					return n, err
+				}
+			}
+			if rf, ok := dst.(io.ReaderFrom); ok {
+				n, err := rf.ReadFrom(b)
+				if err != nil && e.err == nil {
+					e.err = err
+				}
+				return n, err
+			}
+		}
+	}
+	return io.Copy(e, b)
+}
+
 func (e *encoder) writeVarInt(i int64) {
-	b := e.buffer[:]
+	if e.err != nil {
+		return
+	}
+	var b [10]byte
 	u := uint64((i << 1) ^ (i >> 63))
 	n := 0
 
-	for u >= 0x80 && n < len(b) {
+	for u >= 0x80 {
 		b[n] = byte(u) | 0x80
 		u >>= 7
 		n++
 	}
+	b[n] = byte(u)
+	n++
+
+	e.writer.writeb(b[:n])
+	if e.syncErr() {
+		e.update(b[:n])
+	}
+}
 
-	if n < len(b) {
-		b[n] = byte(u)
+// writeUnsignedVarInt writes u using Kafka's unsigned varint encoding, as
+// opposed to writeVarInt which zigzag-encodes a signed value. This is the
+// length-prefix format used by KIP-482 flexible versions: compact strings,
+// compact bytes, compact arrays, and the tagged-fields trailer all encode
+// their lengths and tag ids this way.
+func (e *encoder) writeUnsignedVarInt(u uint64) {
+	if e.err != nil {
+		return
+	}
+	var b [10]byte
+	n := 0
+
+	for u >= 0x80 {
+		b[n] = byte(u) | 0x80
+		u >>= 7
 		n++
 	}
+	b[n] = byte(u)
+	n++
+
+	e.writer.writeb(b[:n])
+	if e.syncErr() {
+		e.update(b[:n])
+	}
+}
+
+// writeCompactArrayLen writes a KIP-482 compact array length, which is the
+// element count plus one so that zero can represent a null array.
+func (e *encoder) writeCompactArrayLen(n int) {
+	e.writeUnsignedVarInt(uint64(n + 1))
+}
+
+// writeCompactStringLen writes a KIP-482 compact string length, which is
+// the byte length plus one so that zero can represent a null string.
+func (e *encoder) writeCompactStringLen(n int) {
+	e.writeUnsignedVarInt(uint64(n + 1))
+}
+
+// writeCompactBytesLen writes a KIP-482 compact bytes length, which is the
+// byte length plus one so that zero can represent null bytes.
+func (e *encoder) writeCompactBytesLen(n int) {
+	e.writeUnsignedVarInt(uint64(n + 1))
+}
+
+// writeTaggedFields writes the KIP-482 tagged-fields trailer that follows
+// every struct in a flexible version, even when there are no tags to
+// write (in which case it is a single zero byte). Tags are written in
+// ascending order as required by the protocol.
+//
+// This package only encodes flexible versions so far: structEncodeFuncOf
+// always calls this with a nil map, since there is no decoder-side (or
+// struct-tag) representation of an unknown/custom tagged field to encode
+// back out yet. Speaking v9+ to a broker end-to-end — decoding the compact
+// lengths and tagged-fields trailer this emits — needs a matching set of
+// changes on the decode.go side that is out of scope for this change.
+func (e *encoder) writeTaggedFields(tags map[int64][]byte) {
+	e.writeUnsignedVarInt(uint64(len(tags)))
+
+	if len(tags) == 0 {
+		return
+	}
+
+	ids := make([]int64, 0, len(tags))
+	for id := range tags {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
 
-	e.Write(b[:n])
+	for _, id := range ids {
+		b := tags[id]
+		e.writeUnsignedVarInt(uint64(id))
+		e.writeUnsignedVarInt(uint64(len(b)))
+		e.Write(b)
+	}
 }
 
 type encodeFunc func(*encoder, value)
@@ -297,6 +661,48 @@ var (
 	writerTo = reflect.TypeOf((*io.WriterTo)(nil)).Elem()
 )
 
+// MarshalAppend encodes msg at the given API version and appends the
+// result to buf, returning the extended slice. Unlike the io.Writer-based
+// marshaling path, it has no io.Writer destination to write through: the
+// message is appended straight into buf by a bytesEncWriter, which is the fast
+// path for pipelined producers that already size and reuse their output
+// buffer across batches.
+func MarshalAppend(buf []byte, msg interface{}, version int16) ([]byte, error) {
+	typ := reflect.TypeOf(msg)
+	val := reflect.ValueOf(msg)
+
+	if typ.Kind() == reflect.Ptr {
+		typ, val = typ.Elem(), val.Elem()
+	}
+
+	w := &bytesEncWriter{buf: buf}
+	e := newEncoder(w)
+	encodeFuncOf(typ, version, structTag{})(e, valueOf(val))
+	return w.buf, e.err
+}
+
+// Marshal encodes msg at the given API version and writes it to w. This is
+// the streaming path used when writing requests and responses straight to
+// a connection: it goes through an ioEncWriter rather than preallocating a
+// buffer, so callers that can size and reuse their own buffer across calls
+// should prefer MarshalAppend instead.
+func Marshal(w io.Writer, msg interface{}, version int16) error {
+	typ := reflect.TypeOf(msg)
+	val := reflect.ValueOf(msg)
+
+	if typ.Kind() == reflect.Ptr {
+		typ, val = typ.Elem(), val.Elem()
+	}
+
+	iw := newIOEncWriter(w)
+	e := newEncoder(iw)
+	encodeFuncOf(typ, version, structTag{})(e, valueOf(val))
+	if err := iw.flush(); err != nil && e.err == nil {
+		e.err = err
+	}
+	return e.err
+}
+
 func encodeFuncOf(typ reflect.Type, version int16, tag structTag) encodeFunc {
 	if reflect.PtrTo(typ).Implements(writerTo) {
 		return writerEncodeFuncOf(typ)
@@ -328,6 +734,10 @@ func encodeFuncOf(typ reflect.Type, version int16, tag structTag) encodeFunc {
 
 func stringEncodeFuncOf(tag structTag) encodeFunc {
 	switch {
+	case tag.Flexible && tag.Nullable:
+		return (*encoder).encodeCompactNullString
+	case tag.Flexible:
+		return (*encoder).encodeCompactString
 	case tag.Nullable:
 		return (*encoder).encodeNullString
 	default:
@@ -337,6 +747,10 @@ func stringEncodeFuncOf(tag structTag) encodeFunc {
 
 func bytesEncodeFuncOf(tag structTag) encodeFunc {
 	switch {
+	case tag.Flexible && tag.Nullable:
+		return (*encoder).encodeCompactNullBytes
+	case tag.Flexible:
+		return (*encoder).encodeCompactBytes
 	case tag.Nullable:
 		return (*encoder).encodeNullBytes
 	default:
@@ -351,6 +765,28 @@ func structEncodeFuncOf(typ reflect.Type, version int16) encodeFunc {
 	}
 
 	var fields []field
+	var flexible bool
+
+	// Whether this struct uses the KIP-482 flexible-version format at
+	// version is a property of the struct as a whole, not of any one
+	// field: a struct made up entirely of plain int32s is just as flexible
+	// as one with a compact string, provided the matched version range
+	// says so (typically via a version-only `_ struct{}` sentinel field).
+	// So this has to walk every field's tag, including struct{} fields
+	// that carry no data and are skipped below when building the
+	// encodable field list.
+	forEachStructField(typ, func(typ reflect.Type, index index, tag string) {
+		forEachStructTag(tag, func(tag structTag) bool {
+			if tag.MinVersion <= version && version <= tag.MaxVersion {
+				if tag.Flexible {
+					flexible = true
+				}
+				return false
+			}
+			return true
+		})
+	})
+
 	forEachStructField(typ, func(typ reflect.Type, index index, tag string) {
 		if typ.Size() != 0 { // skip struct{}
 			forEachStructTag(tag, func(tag structTag) bool {
@@ -371,6 +807,12 @@ func structEncodeFuncOf(typ reflect.Type, version int16) encodeFunc {
 			f := &fields[i]
 			f.encode(e, v.fieldByIndex(f.index))
 		}
+		if flexible {
+			// KIP-482 requires a tagged-fields section after the last
+			// regular field of every struct in a flexible version, even
+			// when there is nothing to tag.
+			e.writeTaggedFields(nil)
+		}
 	}
 }
 
@@ -378,6 +820,10 @@ func arrayEncodeFuncOf(typ reflect.Type, version int16, tag structTag) encodeFun
 	elemType := typ.Elem()
 	elemFunc := encodeFuncOf(elemType, version, tag)
 	switch {
+	case tag.Flexible && tag.Nullable:
+		return func(e *encoder, v value) { e.encodeCompactNullArray(v, elemType, elemFunc) }
+	case tag.Flexible:
+		return func(e *encoder, v value) { e.encodeCompactArray(v, elemType, elemFunc) }
 	case tag.Nullable:
 		return func(e *encoder, v value) { e.encodeNullArray(v, elemType, elemFunc) }
 	default:
@@ -389,9 +835,9 @@ func writerEncodeFuncOf(typ reflect.Type) encodeFunc {
 	typ = reflect.PtrTo(typ)
 	return func(e *encoder, v value) {
 		// Optimization to write directly into the buffer when the encoder
-		// does no need to compute a crc32 checksum.
+		// does no need to compute a checksum.
 		w := io.Writer(e)
-		if e.table == nil {
+		if e.crc == nil {
 			w = e.writer
 		}
 		_, err := v.iface(typ).(io.WriterTo).WriteTo(w)
@@ -415,4 +861,4 @@ func writeInt32(b []byte, i int32) {
 
 func writeInt64(b []byte, i int64) {
 	binary.BigEndian.PutUint64(b, uint64(i))
-}
\ No newline at end of file
+}
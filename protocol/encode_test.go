@@ -0,0 +1,236 @@
+package protocol
+
+import (
+	"bytes"
+	"hash/crc32"
+	"testing"
+)
+
+// testBytes is a minimal Bytes implementation backed by a plain byte
+// slice, used to exercise the writeXxxBytesFrom helpers without needing a
+// real file or network-backed source.
+type testBytes struct {
+	*bytes.Reader
+	size int64
+}
+
+func newTestBytes(b []byte) *testBytes {
+	return &testBytes{Reader: bytes.NewReader(b), size: int64(len(b))}
+}
+
+func (b *testBytes) Size() int64 { return b.size }
+
+func TestWriteUnsignedVarInt(t *testing.T) {
+	tests := []struct {
+		value uint64
+		want  []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{300, []byte{0xac, 0x02}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+	}
+
+	for _, test := range tests {
+		w := &bytesEncWriter{}
+		e := newEncoder(w)
+		e.writeUnsignedVarInt(test.value)
+		if !bytes.Equal(w.buf, test.want) {
+			t.Errorf("writeUnsignedVarInt(%d) = % x, want % x", test.value, w.buf, test.want)
+		}
+	}
+}
+
+func TestWriteCompactStringLen(t *testing.T) {
+	// KIP-482 compact lengths are N+1, with 0 reserved for null.
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x01}},
+		{3, []byte{0x04}},
+		{127, []byte{0x80, 0x01}},
+	}
+
+	for _, test := range tests {
+		w := &bytesEncWriter{}
+		e := newEncoder(w)
+		e.writeCompactStringLen(test.n)
+		if !bytes.Equal(w.buf, test.want) {
+			t.Errorf("writeCompactStringLen(%d) = % x, want % x", test.n, w.buf, test.want)
+		}
+	}
+}
+
+func TestWriteCompactString(t *testing.T) {
+	w := &bytesEncWriter{}
+	e := newEncoder(w)
+	e.writeCompactString("abc")
+
+	want := []byte{0x04, 'a', 'b', 'c'}
+	if !bytes.Equal(w.buf, want) {
+		t.Errorf("writeCompactString(%q) = % x, want % x", "abc", w.buf, want)
+	}
+}
+
+func TestWriteCompactNullStringIsNullSentinel(t *testing.T) {
+	w := &bytesEncWriter{}
+	e := newEncoder(w)
+	e.writeCompactNullString("")
+
+	want := []byte{0x00}
+	if !bytes.Equal(w.buf, want) {
+		t.Errorf("writeCompactNullString(\"\") = % x, want % x", w.buf, want)
+	}
+}
+
+func TestWriteCompactNullBytesFrom(t *testing.T) {
+	w := &bytesEncWriter{}
+	e := newEncoder(w)
+
+	payload := []byte("hello, kafka")
+	if err := e.writeCompactNullBytesFrom(newTestBytes(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	// writeCompactBytesLen(len(payload)) followed by the payload itself.
+	want := append([]byte{byte(len(payload) + 1)}, payload...)
+	if !bytes.Equal(w.buf, want) {
+		t.Errorf("writeCompactNullBytesFrom(%q) = % x, want % x", payload, w.buf, want)
+	}
+}
+
+func TestWriteCompactNullBytesFromNil(t *testing.T) {
+	w := &bytesEncWriter{}
+	e := newEncoder(w)
+
+	if err := e.writeCompactNullBytesFrom(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0x00}
+	if !bytes.Equal(w.buf, want) {
+		t.Errorf("writeCompactNullBytesFrom(nil) = % x, want % x", w.buf, want)
+	}
+}
+
+func TestWriteTaggedFieldsEmpty(t *testing.T) {
+	w := &bytesEncWriter{}
+	e := newEncoder(w)
+	e.writeTaggedFields(nil)
+
+	want := []byte{0x00}
+	if !bytes.Equal(w.buf, want) {
+		t.Errorf("writeTaggedFields(nil) = % x, want % x", w.buf, want)
+	}
+}
+
+func TestWriteTaggedFieldsSortsByID(t *testing.T) {
+	w := &bytesEncWriter{}
+	e := newEncoder(w)
+	e.writeTaggedFields(map[int64][]byte{
+		1: {0xaa},
+		0: {},
+	})
+
+	want := []byte{
+		0x02,       // 2 tags
+		0x00, 0x00, // tag 0, length 0
+		0x01, 0x01, 0xaa, // tag 1, length 1, payload
+	}
+	if !bytes.Equal(w.buf, want) {
+		t.Errorf("writeTaggedFields(...) = % x, want % x", w.buf, want)
+	}
+}
+
+// TestEncoderCRCMatchesIndependentComputation checks that setting a CRC32C
+// checksum on the encoder and writing a mix of primitives through it
+// produces the same checksum as computing CRC32C directly over the bytes
+// that were written, i.e. that update() is being fed the right bytes
+// regardless of which writeIntN/writeCompactX path produced them.
+func TestEncoderCRCMatchesIndependentComputation(t *testing.T) {
+	w := &bytesEncWriter{}
+	e := newEncoder(w)
+	e.setCRC(NewCRC32C())
+
+	e.writeInt8(1)
+	e.writeInt16(2)
+	e.writeInt32(3)
+	e.writeInt64(4)
+	e.writeCompactString("abc")
+	e.writeUnsignedVarInt(42)
+
+	want := crc32.Checksum(w.buf, crc32.MakeTable(crc32.Castagnoli))
+	if got := e.crc.Sum32(); got != want {
+		t.Errorf("encoder CRC32C = %#x, want %#x", got, want)
+	}
+}
+
+func TestEncoderNoCRCConfiguredIsNoop(t *testing.T) {
+	w := &bytesEncWriter{}
+	e := newEncoder(w)
+
+	e.writeInt32(1234)
+	if e.crc != nil {
+		t.Fatalf("expected no checksum to be configured by default")
+	}
+}
+
+func TestIOEncWriterBuffersUntilFlush(t *testing.T) {
+	var dst bytes.Buffer
+	w := newIOEncWriter(&dst)
+
+	w.writen1('a')
+	w.writen2('b', 'c')
+	w.writen4('d', 'e', 'f', 'g')
+
+	if dst.Len() != 0 {
+		t.Fatalf("expected writes to stay buffered before flush, got %d bytes already written", dst.Len())
+	}
+
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "abcdefg"
+	if dst.String() != want {
+		t.Errorf("after flush, dst = %q, want %q", dst.String(), want)
+	}
+}
+
+func TestIOEncWriterRawWriteFlushesFirst(t *testing.T) {
+	var dst bytes.Buffer
+	w := newIOEncWriter(&dst)
+
+	w.writestr("prefix:")
+	raw := w.rawWrite()
+	if _, err := raw.Write([]byte("suffix")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "prefix:suffix"
+	if dst.String() != want {
+		t.Errorf("dst = %q, want %q (buffered bytes must be flushed before raw writes)", dst.String(), want)
+	}
+}
+
+func TestEncoderOverIOEncWriterFlushesToDestination(t *testing.T) {
+	var dst bytes.Buffer
+	iw := newIOEncWriter(&dst)
+	e := newEncoder(iw)
+
+	e.writeInt32(1)
+	e.writeCompactString("x")
+
+	if dst.Len() != 0 {
+		t.Fatalf("expected writes to stay buffered before flush, got %d bytes already written", dst.Len())
+	}
+	if err := iw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Len() == 0 {
+		t.Fatalf("expected flush to deliver buffered bytes to dst")
+	}
+}
@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+// structTag is the parsed form of a `kafka:"..."` struct field tag. It
+// carries the API version range a field is present at, plus the flags that
+// tell encodeFuncOf/decodeFuncOf which wire format to use for that field at
+// a matched version.
+type structTag struct {
+	MinVersion int16
+	MaxVersion int16
+	Nullable   bool
+
+	// Flexible reports whether this field uses the KIP-482 flexible-version
+	// wire format at the version this tag matched: compact strings/bytes/
+	// arrays, and (when set on a struct's own field) a tagged-fields
+	// trailer after the struct's last regular field. It is parsed from the
+	// "flexible" tag option, independently of Nullable, so that a
+	// version-only sentinel field (`_ struct{} \`kafka:"min=v9,max=v9,flexible"\`)
+	// can mark an entire struct flexible without itself being encoded.
+	Flexible bool
+}
+
+// forEachStructTag parses the `kafka:"..."` value of a struct field tag
+// into one or more structTag values, invoking f for each until f returns
+// false or the tag is exhausted. A tag may describe more than one version
+// range (separated by "|"), each with its own flags, so that a field can
+// switch wire format across versions, e.g.
+// `kafka:"min=v0,max=v8|min=v9,max=v9,flexible"`.
+func forEachStructTag(tag string, f func(structTag) bool) {
+	if tag == "" || tag == "-" {
+		return
+	}
+	for _, s := range strings.Split(tag, "|") {
+		if !f(parseStructTag(s)) {
+			return
+		}
+	}
+}
+
+func parseStructTag(s string) structTag {
+	tag := structTag{MaxVersion: -1}
+
+	for _, opt := range strings.Split(s, ",") {
+		switch {
+		case opt == "nullable":
+			tag.Nullable = true
+		case opt == "flexible":
+			tag.Flexible = true
+		case strings.HasPrefix(opt, "min=v"):
+			tag.MinVersion = parseVersion(opt[len("min=v"):])
+		case strings.HasPrefix(opt, "max=v"):
+			tag.MaxVersion = parseVersion(opt[len("max=v"):])
+		}
+	}
+
+	if tag.MaxVersion < 0 {
+		tag.MaxVersion = maxInt16
+	}
+
+	return tag
+}
+
+func parseVersion(s string) int16 {
+	v, err := strconv.ParseInt(s, 10, 16)
+	if err != nil {
+		panic("malformed version number in struct tag: " + s)
+	}
+	return int16(v)
+}
+
+const maxInt16 = 1<<15 - 1
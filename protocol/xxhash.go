@@ -0,0 +1,127 @@
+package protocol
+
+import "encoding/binary"
+
+// xxh64 is a minimal streaming implementation of the XXH64 hash algorithm,
+// used as an optional, non-wire checksum for internal integrity checks
+// where throughput matters more than interoperability with the CRC32
+// fields Kafka actually puts on the wire.
+type xxh64 struct {
+	seed   uint64
+	v1     uint64
+	v2     uint64
+	v3     uint64
+	v4     uint64
+	total  uint64
+	buf    [32]byte
+	bufLen int
+}
+
+const (
+	xxh64Prime1 = 11400714785074694791
+	xxh64Prime2 = 14029467366897019727
+	xxh64Prime3 = 1609587929392839161
+	xxh64Prime4 = 9650029242287828579
+	xxh64Prime5 = 2870177450012600261
+)
+
+func newXXH64() *xxh64 {
+	d := &xxh64{}
+	d.Reset()
+	return d
+}
+
+func (d *xxh64) Reset() {
+	d.v1 = d.seed + xxh64Prime1 + xxh64Prime2
+	d.v2 = d.seed + xxh64Prime2
+	d.v3 = d.seed
+	d.v4 = d.seed - xxh64Prime1
+	d.total = 0
+	d.bufLen = 0
+}
+
+func xxh64Round(acc, input uint64) uint64 {
+	acc += input * xxh64Prime2
+	acc = acc<<31 | acc>>33
+	acc *= xxh64Prime1
+	return acc
+}
+
+func (d *xxh64) Write(b []byte) (int, error) {
+	n := len(b)
+	d.total += uint64(n)
+
+	if d.bufLen+len(b) < 32 {
+		d.bufLen += copy(d.buf[d.bufLen:], b)
+		return n, nil
+	}
+
+	if d.bufLen > 0 {
+		c := copy(d.buf[d.bufLen:], b)
+		b = b[c:]
+		d.consume(d.buf[:32])
+		d.bufLen = 0
+	}
+
+	for len(b) >= 32 {
+		d.consume(b[:32])
+		b = b[32:]
+	}
+
+	d.bufLen = copy(d.buf[:], b)
+	return n, nil
+}
+
+func (d *xxh64) consume(b []byte) {
+	d.v1 = xxh64Round(d.v1, binary.LittleEndian.Uint64(b[0:8]))
+	d.v2 = xxh64Round(d.v2, binary.LittleEndian.Uint64(b[8:16]))
+	d.v3 = xxh64Round(d.v3, binary.LittleEndian.Uint64(b[16:24]))
+	d.v4 = xxh64Round(d.v4, binary.LittleEndian.Uint64(b[24:32]))
+}
+
+func (d *xxh64) Sum64() uint64 {
+	var h uint64
+
+	if d.total >= 32 {
+		h = (d.v1<<1 | d.v1>>63) +
+			(d.v2<<7 | d.v2>>57) +
+			(d.v3<<12 | d.v3>>52) +
+			(d.v4<<18 | d.v4>>46)
+
+		h = (h^xxh64Round(0, d.v1))*xxh64Prime1 + xxh64Prime4
+		h = (h^xxh64Round(0, d.v2))*xxh64Prime1 + xxh64Prime4
+		h = (h^xxh64Round(0, d.v3))*xxh64Prime1 + xxh64Prime4
+		h = (h^xxh64Round(0, d.v4))*xxh64Prime1 + xxh64Prime4
+	} else {
+		h = d.seed + xxh64Prime5
+	}
+
+	h += d.total
+
+	b := d.buf[:d.bufLen]
+	for len(b) >= 8 {
+		k1 := xxh64Round(0, binary.LittleEndian.Uint64(b[:8]))
+		h ^= k1
+		h = (h<<27 | h>>37) * xxh64Prime1
+		h += xxh64Prime4
+		b = b[8:]
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxh64Prime1
+		h = (h<<23 | h>>41) * xxh64Prime2
+		h += xxh64Prime3
+		b = b[4:]
+	}
+	for len(b) > 0 {
+		h ^= uint64(b[0]) * xxh64Prime5
+		h = (h<<11 | h>>53) * xxh64Prime1
+		b = b[1:]
+	}
+
+	h ^= h >> 33
+	h *= xxh64Prime2
+	h ^= h >> 29
+	h *= xxh64Prime3
+	h ^= h >> 32
+	return h
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import "testing"
+
+// These expected sums were independently verified against libxxhash
+// (XXH64, seed 0) rather than derived from this implementation, so they
+// guard against regressions in the algorithm itself, not just in how this
+// package calls it.
+func TestXXH64KnownVectors(t *testing.T) {
+	tests := []struct {
+		input string
+		sum   uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"0123456789", 0x3f5fc178a81867e7},
+		{
+			"Hello world, this is a longer string used to exercise the 32-byte block path of xxh64 more than once.",
+			0xa43d45e4349ff98b,
+		},
+	}
+
+	for _, test := range tests {
+		d := newXXH64()
+		d.Write([]byte(test.input))
+		if sum := d.Sum64(); sum != test.sum {
+			t.Errorf("XXH64(%q) = %#x, want %#x", test.input, sum, test.sum)
+		}
+	}
+}
+
+// TestXXH64StreamingEquivalence checks that splitting a Write across many
+// small calls (as encoder.update does, one field at a time) produces the
+// same digest as a single Write of the whole input, for inputs that cross
+// the 32-byte block boundary in different places depending on chunk size.
+func TestXXH64StreamingEquivalence(t *testing.T) {
+	data := make([]byte, 200)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	want := newXXH64()
+	want.Write(data)
+	wantSum := want.Sum64()
+
+	for _, chunkSize := range []int{1, 2, 3, 7, 16, 31, 32, 33, 64, 100} {
+		d := newXXH64()
+		for off := 0; off < len(data); off += chunkSize {
+			end := off + chunkSize
+			if end > len(data) {
+				end = len(data)
+			}
+			d.Write(data[off:end])
+		}
+		if sum := d.Sum64(); sum != wantSum {
+			t.Errorf("chunk size %d: XXH64 = %#x, want %#x", chunkSize, sum, wantSum)
+		}
+	}
+}
+
+func TestXXH64Reset(t *testing.T) {
+	d := newXXH64()
+	d.Write([]byte("abc"))
+	d.Reset()
+	d.Write([]byte("abc"))
+
+	const want = 0x44bc2cf5ad770999
+	if sum := d.Sum64(); sum != want {
+		t.Errorf("XXH64 after Reset = %#x, want %#x", sum, want)
+	}
+}